@@ -0,0 +1,164 @@
+// Package audit 实现一个防篡改的追加式审计日志：每条记录都通过一条以
+// HMAC-SHA256 计算的哈希链与前一条记录绑定，篡改或删除任意一条记录都会
+// 导致其后所有记录的哈希校验失败。
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry 是审计日志里的一条记录。
+type Entry struct {
+	PrevHash  string          `json:"prev_hash"`
+	Timestamp int64           `json:"timestamp"`
+	Event     string          `json:"event"`
+	Row       json.RawMessage `json:"row_json"`
+	HMAC      string          `json:"hmac"`
+}
+
+// Logger 把记录以 HMAC 哈希链的形式追加写入本地文件。Key 只存在于
+// config.yml（由调用方传入），不会被写入日志文件本身，
+// 因此仅凭日志文件无法伪造后续记录。
+type Logger struct {
+	mu       sync.Mutex
+	file     *os.File
+	key      []byte
+	lastHash string
+}
+
+// NewLogger 打开（或创建）path 处的审计日志，并从其中恢复哈希链的当前尾部。
+func NewLogger(path string, key []byte) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("打开审计日志失败: %w", err)
+	}
+
+	lastHash, err := lastEntryHash(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("恢复审计日志哈希链失败: %w", err)
+	}
+
+	return &Logger{file: f, key: key, lastHash: lastHash}, nil
+}
+
+// Append 追加一条事件记录，并把它链接到当前哈希链的尾部。
+func (l *Logger) Append(event string, row interface{}) error {
+	rowJSON, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Errorf("序列化行数据失败: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := Entry{
+		PrevHash:  l.lastHash,
+		Timestamp: time.Now().Unix(),
+		Event:     event,
+		Row:       rowJSON,
+	}
+	entry.HMAC = computeHMAC(l.key, entry)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("序列化审计记录失败: %w", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("写入审计日志失败: %w", err)
+	}
+
+	l.lastHash = entry.HMAC
+	return nil
+}
+
+// Close 关闭底层文件句柄。
+func (l *Logger) Close() error {
+	return l.file.Close()
+}
+
+// computeHMAC 计算一条记录在哈希链中的 HMAC-SHA256 值。
+func computeHMAC(key []byte, e Entry) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s|%d|%s|%s", e.PrevHash, e.Timestamp, e.Event, e.Row)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lastEntryHash 读取文件中最后一条记录的 HMAC，作为哈希链的当前尾部；
+// 文件为空时返回空字符串，代表哈希链的创世状态。
+func lastEntryHash(f *os.File) (string, error) {
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+
+	var lastLine string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lastLine = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, 2); err != nil {
+		return "", err
+	}
+	if lastLine == "" {
+		return "", nil
+	}
+
+	var entry Entry
+	if err := json.Unmarshal([]byte(lastLine), &entry); err != nil {
+		return "", fmt.Errorf("解析末尾记录失败: %w", err)
+	}
+	return entry.HMAC, nil
+}
+
+// VerifyFile 重放 path 处的哈希链，返回第一处校验失败的行号（从 1 开始）。
+// 返回 0 表示整条链完整无误。
+func VerifyFile(path string, key []byte) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("打开审计日志失败: %w", err)
+	}
+	defer f.Close()
+
+	prevHash := ""
+	lineNo := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return lineNo, fmt.Errorf("第 %d 行不是合法的审计记录: %w", lineNo, err)
+		}
+		if entry.PrevHash != prevHash {
+			return lineNo, nil
+		}
+		if computeHMAC(key, entry) != entry.HMAC {
+			return lineNo, nil
+		}
+		prevHash = entry.HMAC
+	}
+	if err := scanner.Err(); err != nil {
+		return lineNo, err
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyFile(t *testing.T) {
+	key := []byte("test-key")
+
+	newChainFile := func(t *testing.T) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "audit.log")
+		l, err := NewLogger(path, key)
+		if err != nil {
+			t.Fatalf("NewLogger: %v", err)
+		}
+		defer l.Close()
+		if err := l.Append("unauthorized_insert", map[string]interface{}{"id": 1}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := l.Append("unauthorized_update", map[string]interface{}{"id": 1, "name": "new"}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if err := l.Append("unauthorized_delete", map[string]interface{}{"id": 2}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		return path
+	}
+
+	t.Run("完整的哈希链校验通过", func(t *testing.T) {
+		path := newChainFile(t)
+		line, err := VerifyFile(path, key)
+		if err != nil {
+			t.Fatalf("VerifyFile: %v", err)
+		}
+		if line != 0 {
+			t.Fatalf("期望校验通过（返回 0），实际在第 %d 行失败", line)
+		}
+	})
+
+	t.Run("中间一行被篡改后校验在该行失败", func(t *testing.T) {
+		path := newChainFile(t)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		lines := strings.Split(strings.TrimRight(string(raw), "\n"), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("期望 3 行记录，实际 %d 行", len(lines))
+		}
+		// 篡改第 2 行的事件名，HMAC 未变，链应在第 2 行校验失败。
+		lines[1] = strings.Replace(lines[1], "unauthorized_update", "unauthorized_insert", 1)
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+
+		line, err := VerifyFile(path, key)
+		if err != nil {
+			t.Fatalf("VerifyFile: %v", err)
+		}
+		if line != 2 {
+			t.Fatalf("期望在第 2 行发现篡改，实际结果为 %d", line)
+		}
+	})
+
+	t.Run("文件被截断导致末尾记录损坏", func(t *testing.T) {
+		path := newChainFile(t)
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		// 截掉最后一行的后半部分，模拟进程崩溃导致的半截写入。
+		truncated := raw[:len(raw)-10]
+		if err := os.WriteFile(path, truncated, 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+
+		line, err := VerifyFile(path, key)
+		if err == nil {
+			t.Fatalf("期望截断文件返回解析错误，实际 line=%d, err=nil", line)
+		}
+		if line != 3 {
+			t.Fatalf("期望在第 3 行（被截断的记录）报错，实际为第 %d 行", line)
+		}
+	})
+
+	t.Run("用错误的密钥校验会在第一条记录失败", func(t *testing.T) {
+		path := newChainFile(t)
+		line, err := VerifyFile(path, []byte("wrong-key"))
+		if err != nil {
+			t.Fatalf("VerifyFile: %v", err)
+		}
+		if line != 1 {
+			t.Fatalf("期望密钥错误导致第 1 行 HMAC 校验失败，实际结果为 %d", line)
+		}
+	})
+}
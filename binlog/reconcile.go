@@ -0,0 +1,143 @@
+package binlog
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+
+	"github.com/hoshinonyaruko/acgv3-safeguard/metrics"
+)
+
+// reconcileFromBaseline 在进程重启、且没有可恢复的 binlog 位点时，把每张受保护表的
+// 当前状态与持久化的可信基线逐行比对，修复停机期间发生的未授权变更——语义上对应
+// 轮询路径 protect.go 的 restoreTableState，只是只需要处理单列主键（binlog 消费者
+// 的限制，见 main.go startBinlogProtection 对复合主键的拒绝）。比对完成后
+// Watcher.Run 才会订阅 binlog，确保不会带着"停机期间被篡改而未发现"的状态进入
+// 实时监听。
+func (w *Watcher) reconcileFromBaseline() error {
+	if w.cfg.BaselineStore == nil {
+		w.logger.Warn("binlog: 未配置基线存储，跳过重启后的全表核对")
+		return nil
+	}
+	for _, t := range w.cfg.Tables {
+		if err := w.reconcileTable(t); err != nil {
+			return fmt.Errorf("核对表 %s.%s 失败: %w", t.Database, t.Table, err)
+		}
+	}
+	return nil
+}
+
+// reconcileTable 核对单张表。baseline 为 nil（从未执行过 trust-current）时只记录
+// 警告并跳过——这种情况下没有可信状态可比对，强行拿当前表状态当基线等于相信
+// 停机期间可能已经发生的篡改，不如维持原状、交给人工确认。
+func (w *Watcher) reconcileTable(t TableConfig) error {
+	qualifiedTable := t.Database + "." + t.Table
+
+	baseline, err := w.cfg.BaselineStore.Load(qualifiedTable)
+	if err != nil {
+		return fmt.Errorf("加载持久化基线失败: %w", err)
+	}
+	if baseline == nil {
+		w.logger.Warn("binlog: 未找到持久化基线，跳过重启后的全表核对；请执行 trust-current 固化基线", "table", qualifiedTable)
+		return nil
+	}
+
+	current, err := fetchTableRows(w.db, qualifiedTable)
+	if err != nil {
+		return fmt.Errorf("扫描当前状态失败: %w", err)
+	}
+
+	baselineMap := rowsByPK(baseline, t.PKColumn)
+	currentMap := rowsByPK(current, t.PKColumn)
+
+	for pk, row := range currentMap {
+		if _, ok := baselineMap[pk]; ok {
+			continue
+		}
+		if t.AllowInsertsMatching != "" {
+			matches, err := w.insertMatchesPredicate(t, pk)
+			if err != nil {
+				return err
+			}
+			if matches {
+				w.logger.Info("binlog: 重启核对发现的新增记录匹配 AllowInsertsMatching，予以保留", "table", qualifiedTable, "pk_value", pk)
+				continue
+			}
+		}
+		if err := w.deleteRowByPK(t, pk); err != nil {
+			return fmt.Errorf("回滚未授权的新增记录失败: %w", err)
+		}
+		metrics.RowsRestored.WithLabelValues(qualifiedTable, "insert").Inc()
+		w.logger.Warn("binlog: 重启核对发现未授权新增，已回滚", "table", qualifiedTable, "pk_value", pk)
+		w.appendAudit("unauthorized_insert", t, row)
+	}
+
+	for pk, row := range baselineMap {
+		if _, ok := currentMap[pk]; ok {
+			continue
+		}
+		if err := w.insertRow(t, row); err != nil {
+			return fmt.Errorf("还原未授权的删除记录失败: %w", err)
+		}
+		metrics.RowsRestored.WithLabelValues(qualifiedTable, "delete").Inc()
+		w.logger.Warn("binlog: 重启核对发现未授权删除，已还原", "table", qualifiedTable, "pk_value", pk)
+		w.appendAudit("unauthorized_delete", t, row)
+	}
+
+	for pk, baseRow := range baselineMap {
+		curRow, ok := currentMap[pk]
+		if !ok || reflect.DeepEqual(baseRow, curRow) {
+			continue
+		}
+		if _, err := w.updateRow(t, baseRow); err != nil {
+			return fmt.Errorf("还原未授权的修改记录失败: %w", err)
+		}
+		metrics.RowsRestored.WithLabelValues(qualifiedTable, "update").Inc()
+		w.logger.Warn("binlog: 重启核对发现未授权修改，已还原", "table", qualifiedTable, "pk_value", pk)
+		w.appendAudit("unauthorized_update", t, curRow)
+	}
+
+	metrics.LastSuccessfulScan.WithLabelValues(qualifiedTable).SetToCurrentTime()
+	return nil
+}
+
+// fetchTableRows 不加锁地读出整张表，仅用于重启后的一次性核对（此时消费者还没有
+// 订阅 binlog，不存在"核对期间并发写入"的窗口）。
+func fetchTableRows(db *sql.DB, qualifiedTable string) ([]map[string]interface{}, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s;", qualifiedTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		row := make(map[string]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range ptrs {
+			ptrs[i] = new(interface{})
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, col := range cols {
+			row[col] = *(ptrs[i].(*interface{}))
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// rowsByPK 把一批行按主键值索引成 map，便于与另一批行逐一比对。
+func rowsByPK(rows []map[string]interface{}, pkColumn string) map[string]map[string]interface{} {
+	m := make(map[string]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		m[fmt.Sprintf("%v", row[pkColumn])] = row
+	}
+	return m
+}
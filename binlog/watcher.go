@@ -0,0 +1,456 @@
+// Package binlog 基于 MySQL binlog 行事件实现表保护，替代轮询式的全表扫描。
+package binlog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/hoshinonyaruko/acgv3-safeguard/audit"
+	"github.com/hoshinonyaruko/acgv3-safeguard/metrics"
+	"github.com/hoshinonyaruko/acgv3-safeguard/store"
+)
+
+// positionBucket 存放 Watcher 最近一次确认处理过的 binlog 位点，使进程重启后可以
+// 从断点续传，而不是像最初实现那样每次都从当前 binlog tip 开始——那会让停机期间
+// 发生的所有行变更既不被回滚也不被发现，完全违背本包"毫秒级发现未授权变更"的初衷。
+var positionBucket = []byte("position")
+
+// positionKey 是 positionBucket 里保存位点的固定 key；一个 Watcher 实例只消费一份
+// binlog 流，不需要按表区分。
+var positionKey = []byte("pos")
+
+// TableConfig 描述一张受 binlog 保护的表。
+//
+// binlog 消费者目前只实现 config.TableRule 里 immutable 模式的语义（整表
+// 与基线逐行比对，新增/删除/修改一律还原）；append_only/pinned_row 这类
+// 需要区分"允许新增"或"按条件保留"的模式不在 binlog 路径支持范围内，
+// 调用方（见 main.go startBinlogProtection）必须在启动时校验并拒绝这类组合。
+type TableConfig struct {
+	Database string
+	Table    string
+	// PKColumn 是该表的主键列名，目前仅支持单列主键。
+	PKColumn string
+	// AllowInsertsMatching 与 config.TableRule.AllowInsertsMatching 语义相同：
+	// 新增记录若匹配该谓词（例如支付 webhook 插入的新订单），视为合法业务写入，
+	// 不回滚；为空表示维持原有行为，所有新增记录都会被删除。
+	AllowInsertsMatching string
+}
+
+// Config 是启动 binlog 消费者所需的配置。
+type Config struct {
+	Address  string
+	Username string
+	Password string
+	ServerID uint32
+
+	Tables []TableConfig
+
+	// CachePath 是存放已处理 binlog 位点的 BoltDB 文件路径，用于重启续传。
+	CachePath string
+
+	// AuthorizedGTIDs 列出可信的运维时间窗口对应的 GTID，
+	// 落在这些 GTID 之后、直到下一个事务提交前的行变更会被视为授权变更，不会被回滚。
+	AuthorizedGTIDs []string
+
+	// BaselineStore 是持久化的可信基线快照，与轮询路径（protect.go）共用同一套存储。
+	// Run 在找不到持久化 binlog 位点时（进程首次启动，或缓存文件丢失）用它核对
+	// 停机期间是否发生了未授权变更；binlog 消费者本身只读取，不写入——基线只能
+	// 通过 trust-current 显式固化，避免攻破数据库的攻击者靠重启悄悄把自己的改动
+	// 变成新基线。为 nil 时跳过重启核对，直接从当前 binlog tip 开始订阅。
+	BaselineStore store.BaselineStore
+}
+
+// Watcher 消费 binlog 行事件，并在发现未授权变更时在有界延迟内生成反向语句进行回滚。
+type Watcher struct {
+	canal.DummyEventHandler
+
+	cfg         Config
+	db          *sql.DB
+	cache       *bolt.DB
+	logger      *slog.Logger
+	auditLogger *audit.Logger
+	c           *canal.Canal
+
+	mu         sync.RWMutex
+	tablesByID map[string]TableConfig // "db.table" -> TableConfig
+	authorized map[string]bool        // 授权维护窗口的 GTID 集合
+	inWindow   bool                   // 当前事务是否处于授权维护窗口内
+}
+
+// NewWatcher 创建一个 binlog 表保护消费者。auditLogger 用于把每一次回滚写入
+// 防篡改的哈希链审计日志，使 binlog 路径的回滚与轮询路径一样可被 verify-log 校验。
+func NewWatcher(cfg Config, db *sql.DB, logger *slog.Logger, auditLogger *audit.Logger) (*Watcher, error) {
+	cache, err := bolt.Open(cfg.CachePath, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("打开位点缓存失败: %w", err)
+	}
+	if err := cache.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(positionBucket)
+		return err
+	}); err != nil {
+		cache.Close()
+		return nil, fmt.Errorf("初始化位点缓存失败: %w", err)
+	}
+
+	tablesByID := make(map[string]TableConfig, len(cfg.Tables))
+	authorized := make(map[string]bool, len(cfg.AuthorizedGTIDs))
+	for _, t := range cfg.Tables {
+		tablesByID[t.Database+"."+t.Table] = t
+	}
+	for _, g := range cfg.AuthorizedGTIDs {
+		authorized[g] = true
+	}
+
+	w := &Watcher{
+		cfg:         cfg,
+		db:          db,
+		cache:       cache,
+		logger:      logger,
+		auditLogger: auditLogger,
+		tablesByID:  tablesByID,
+		authorized:  authorized,
+	}
+
+	canalCfg := canal.NewDefaultConfig()
+	canalCfg.Addr = cfg.Address
+	canalCfg.User = cfg.Username
+	canalCfg.Password = cfg.Password
+	canalCfg.ServerID = cfg.ServerID
+	canalCfg.Dump.ExecutionPath = "" // 不依赖 mysqldump，只消费 binlog；起始位点由 Run 决定
+	for _, t := range cfg.Tables {
+		canalCfg.IncludeTableRegex = append(canalCfg.IncludeTableRegex, fmt.Sprintf("%s\\.%s", t.Database, t.Table))
+	}
+
+	c, err := canal.NewCanal(canalCfg)
+	if err != nil {
+		cache.Close()
+		return nil, fmt.Errorf("创建 binlog 消费者失败: %w", err)
+	}
+	c.SetEventHandler(w)
+	w.c = c
+
+	return w, nil
+}
+
+// Run 消费行事件，阻塞直到出错或 Close 被调用。
+//
+// 优先从上次持久化的位点续传（见 OnPosSynced）；只有从未成功持久化过位点时
+// （进程首次启动，或位点缓存文件丢失），才退化为从当前 binlog tip 开始，
+// 并在此之前用 BaselineStore 对所有受保护表做一次全表核对，堵上"重启期间的
+// 变更既不回滚也不被发现"的窗口。
+func (w *Watcher) Run() error {
+	pos, ok, err := w.loadPosition()
+	if err != nil {
+		return fmt.Errorf("读取持久化的 binlog 位点失败: %w", err)
+	}
+	if ok {
+		w.logger.Info("binlog: 从持久化位点续传", "position", pos.String())
+		return w.c.RunFrom(pos)
+	}
+
+	w.logger.Warn("binlog: 未找到持久化位点，订阅前对所有受保护表做一次全表核对")
+	if err := w.reconcileFromBaseline(); err != nil {
+		return fmt.Errorf("重启后全表核对失败: %w", err)
+	}
+
+	pos, err = w.c.GetMasterPos()
+	if err != nil {
+		return fmt.Errorf("获取当前 binlog 位点失败: %w", err)
+	}
+	return w.c.RunFrom(pos)
+}
+
+// loadPosition 读取上一次持久化的 binlog 位点；从未持久化过时返回 ok=false。
+func (w *Watcher) loadPosition() (pos mysql.Position, ok bool, err error) {
+	err = w.cache.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(positionBucket).Get(positionKey)
+		if raw == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(raw, &pos)
+	})
+	return pos, ok, err
+}
+
+// OnPosSynced 实现 canal.EventHandler：canal 在同步位点时调用它，
+// 这里把位点落盘到 BoltDB，使 Run 能在下次启动时续传。
+func (w *Watcher) OnPosSynced(header *replication.EventHeader, pos mysql.Position, set mysql.GTIDSet, force bool) error {
+	raw, err := json.Marshal(pos)
+	if err != nil {
+		return fmt.Errorf("序列化 binlog 位点失败: %w", err)
+	}
+	return w.cache.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(positionBucket).Put(positionKey, raw)
+	})
+}
+
+// Close 停止消费并释放底层资源。
+func (w *Watcher) Close() {
+	w.c.Close()
+	w.cache.Close()
+}
+
+// OnGTID 在每个事务提交前被调用，用于判断该事务是否落在授权维护窗口内。
+func (w *Watcher) OnGTID(header *replication.EventHeader, set mysql.GTIDSet) error {
+	w.mu.Lock()
+	w.inWindow = w.authorized[set.String()]
+	w.mu.Unlock()
+	return nil
+}
+
+// OnRow 处理单个行事件：授权窗口内的变更予以放行，否则生成反向语句进行回滚。
+//
+// 授权维护窗口只影响"是否回滚"这一次判断，不会更新任何持久化状态——可信基线
+// 仍然只能通过 trust-current 显式固化（见 Config.BaselineStore 的文档注释），
+// 这样窗口结束后，只要操作者没有手动确认，下一次重启核对依然会按旧基线比对。
+func (w *Watcher) OnRow(e *canal.RowsEvent) error {
+	key := e.Table.Schema + "." + e.Table.Name
+	tblCfg, ok := w.tablesByID[key]
+	if !ok {
+		return nil
+	}
+
+	w.mu.RLock()
+	authorized := w.inWindow
+	w.mu.RUnlock()
+
+	if authorized {
+		w.logger.Info("binlog: 授权维护窗口内的变更，不予回滚", "table", key)
+		metrics.LastSuccessfulScan.WithLabelValues(key).SetToCurrentTime()
+		return nil
+	}
+
+	switch e.Action {
+	case canal.InsertAction:
+		for _, row := range e.Rows {
+			if err := w.rollbackInsert(tblCfg, e.Table.Columns, row); err != nil {
+				return err
+			}
+		}
+	case canal.DeleteAction:
+		for _, row := range e.Rows {
+			if err := w.rollbackDelete(tblCfg, e.Table.Columns, row); err != nil {
+				return err
+			}
+		}
+	case canal.UpdateAction:
+		// update 事件成对出现：[旧值, 新值]
+		for i := 0; i+1 < len(e.Rows); i += 2 {
+			if err := w.rollbackUpdate(tblCfg, e.Table.Columns, e.Rows[i], e.Rows[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *Watcher) rollbackInsert(t TableConfig, cols []schema.TableColumn, row []interface{}) error {
+	pk, err := pkValue(t, cols, row)
+	if err != nil {
+		return err
+	}
+
+	if t.AllowInsertsMatching != "" {
+		matches, err := w.insertMatchesPredicate(t, pk)
+		if err != nil {
+			return err
+		}
+		if matches {
+			w.logger.Info("binlog: 新增记录匹配 AllowInsertsMatching，予以保留", "table", t.Database+"."+t.Table, "pk_column", t.PKColumn, "pk_value", pk)
+			return nil
+		}
+	}
+
+	if err := w.deleteRowByPK(t, pk); err != nil {
+		return fmt.Errorf("回滚未授权的新增记录失败: %w", err)
+	}
+	metrics.RowsRestored.WithLabelValues(t.Database+"."+t.Table, "insert").Inc()
+	w.logger.Info("binlog: 回滚未授权新增", "table", t.Database+"."+t.Table, "pk_column", t.PKColumn, "pk_value", pk)
+	w.appendAudit("unauthorized_insert", t, rowToMap(cols, row))
+	return nil
+}
+
+func (w *Watcher) rollbackDelete(t TableConfig, cols []schema.TableColumn, row []interface{}) error {
+	rowMap := rowToMap(cols, row)
+	if err := w.insertRow(t, rowMap); err != nil {
+		return fmt.Errorf("还原未授权的删除记录失败: %w", err)
+	}
+	metrics.RowsRestored.WithLabelValues(t.Database+"."+t.Table, "delete").Inc()
+	w.logger.Info("binlog: 还原未授权删除", "table", t.Database+"."+t.Table)
+	w.appendAudit("unauthorized_delete", t, rowMap)
+	return nil
+}
+
+// rollbackUpdate 还原一次未授权的 UPDATE。oldRow/newRow 是该行变更前后的完整镜像。
+//
+// 如果这次 UPDATE 改动的正是主键列本身，行的当前主键就是 newRow 对应的值，
+// 用 "WHERE PKColumn = oldRow 的旧主键" 定位会匹配不到任何行——语句本身不会
+// 报错（RowsAffected 为 0），篡改后的行却完全没被还原，相当于靠改主键就能
+// 绕过 immutable 模式。遇到这种情况改为"删除新主键对应的行 + 按旧镜像重新插入"，
+// 和 rollbackDelete 还原整行删除是同一套做法；主键没变时维持原来的原地 UPDATE，
+// 但要检查 RowsAffected，0 行说明目标行已经不在了，同样需要回退为重新插入。
+func (w *Watcher) rollbackUpdate(t TableConfig, cols []schema.TableColumn, oldRow, newRow []interface{}) error {
+	oldRowMap := rowToMap(cols, oldRow)
+	oldPK, err := pkValue(t, cols, oldRow)
+	if err != nil {
+		return err
+	}
+	newPK, err := pkValue(t, cols, newRow)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(oldPK, newPK) {
+		if err := w.deleteRowByPK(t, newPK); err != nil {
+			return fmt.Errorf("还原未授权的主键修改失败: %w", err)
+		}
+		if err := w.insertRow(t, oldRowMap); err != nil {
+			return fmt.Errorf("还原未授权的主键修改失败: %w", err)
+		}
+		metrics.RowsRestored.WithLabelValues(t.Database+"."+t.Table, "update").Inc()
+		w.logger.Warn("binlog: 还原未授权的主键修改", "table", t.Database+"."+t.Table, "pk_column", t.PKColumn, "old_pk_value", oldPK, "new_pk_value", newPK)
+		w.appendAudit("unauthorized_update", t, oldRowMap)
+		return nil
+	}
+
+	affected, err := w.updateRow(t, oldRowMap)
+	if err != nil {
+		return fmt.Errorf("还原未授权的修改记录失败: %w", err)
+	}
+	if affected == 0 {
+		// 目标行已经不存在（例如被另一笔未授权变更删除），原地 UPDATE 无从生效，
+		// 退化为重新插入旧镜像，否则这次回滚会被无声吞掉。
+		if err := w.insertRow(t, oldRowMap); err != nil {
+			return fmt.Errorf("还原未授权的修改记录失败: %w", err)
+		}
+	}
+	metrics.RowsRestored.WithLabelValues(t.Database+"."+t.Table, "update").Inc()
+	w.logger.Info("binlog: 还原未授权修改", "table", t.Database+"."+t.Table, "pk_column", t.PKColumn, "pk_value", oldPK)
+	w.appendAudit("unauthorized_update", t, oldRowMap)
+	return nil
+}
+
+// deleteRowByPK 按主键值删除一行，供回滚未授权新增、以及主键被篡改时的"先删新行"复用。
+func (w *Watcher) deleteRowByPK(t TableConfig, pk interface{}) error {
+	query := fmt.Sprintf("DELETE FROM %s.%s WHERE %s = ?;", t.Database, t.Table, t.PKColumn)
+	if _, err := w.db.Exec(query, pk); err != nil {
+		metrics.DBErrors.Inc()
+		return err
+	}
+	return nil
+}
+
+// insertRow 按列名插入一整行，供回滚未授权删除、以及主键被篡改时的"按旧镜像重新插入"复用。
+func (w *Watcher) insertRow(t TableConfig, row map[string]interface{}) error {
+	columns := make([]string, 0, len(row))
+	placeholders := make([]string, 0, len(row))
+	values := make([]interface{}, 0, len(row))
+	for col, val := range row {
+		columns = append(columns, col)
+		placeholders = append(placeholders, "?")
+		values = append(values, val)
+	}
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s);", t.Database, t.Table, joinComma(columns), joinComma(placeholders))
+	if _, err := w.db.Exec(query, values...); err != nil {
+		metrics.DBErrors.Inc()
+		return err
+	}
+	return nil
+}
+
+// updateRow 把 row 里除主键列以外的值原地写回，按主键定位目标行，返回受影响的行数；
+// 调用方据此判断目标行是否还存在（见 rollbackUpdate）。
+func (w *Watcher) updateRow(t TableConfig, row map[string]interface{}) (int64, error) {
+	assignments := make([]string, 0, len(row))
+	values := make([]interface{}, 0, len(row))
+	for col, val := range row {
+		if col == t.PKColumn {
+			continue
+		}
+		assignments = append(assignments, col+" = ?")
+		values = append(values, val)
+	}
+	values = append(values, row[t.PKColumn])
+	query := fmt.Sprintf("UPDATE %s.%s SET %s WHERE %s = ?;", t.Database, t.Table, joinComma(assignments), t.PKColumn)
+	res, err := w.db.Exec(query, values...)
+	if err != nil {
+		metrics.DBErrors.Inc()
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		metrics.DBErrors.Inc()
+		return 0, err
+	}
+	return affected, nil
+}
+
+// appendAudit 把一次回滚写入防篡改的哈希链审计日志，使 binlog 路径的回滚
+// 和轮询路径（protect.go）一样能被 verify-log 事后校验。auditLogger 为 nil
+// 时（未配置审计日志）静默跳过。
+func (w *Watcher) appendAudit(event string, t TableConfig, row map[string]interface{}) {
+	if w.auditLogger == nil {
+		return
+	}
+	if err := w.auditLogger.Append(event, row); err != nil {
+		w.logger.Error("写入审计日志失败", "table", t.Database+"."+t.Table, "error", err)
+	}
+}
+
+// rowToMap 把 binlog 行事件里的列值数组还原成列名到值的 map，便于写入审计日志。
+func rowToMap(cols []schema.TableColumn, row []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(cols))
+	for i, c := range cols {
+		m[c.Name] = row[i]
+	}
+	return m
+}
+
+// insertMatchesPredicate 重新向数据库求值 t.AllowInsertsMatching，判断 pk 对应的
+// 新增行是否匹配。交给 MySQL 求值而不是在 Go 端重新实现 SQL 表达式语义，
+// 与轮询路径的 rowMatchesPredicate（protect.go）思路一致。
+func (w *Watcher) insertMatchesPredicate(t TableConfig, pk interface{}) (bool, error) {
+	query := fmt.Sprintf("SELECT 1 FROM %s.%s WHERE %s = ? AND (%s) LIMIT 1;", t.Database, t.Table, t.PKColumn, t.AllowInsertsMatching)
+	var exists int
+	err := w.db.QueryRow(query, pk).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		metrics.DBErrors.Inc()
+		return false, fmt.Errorf("校验 AllowInsertsMatching 条件失败: %w", err)
+	}
+	return true, nil
+}
+
+func pkValue(t TableConfig, cols []schema.TableColumn, row []interface{}) (interface{}, error) {
+	for i, c := range cols {
+		if c.Name == t.PKColumn {
+			return row[i], nil
+		}
+	}
+	return nil, fmt.Errorf("表 %s.%s 未找到主键列 %s", t.Database, t.Table, t.PKColumn)
+}
+
+func joinComma(parts []string) string {
+	out := ""
+	for i, p := range parts {
+		if i > 0 {
+			out += ", "
+		}
+		out += p
+	}
+	return out
+}
@@ -22,10 +22,114 @@ type Config struct {
 		PluginOverrideTarget string `yaml:"plugin_override_target"`
 	} `yaml:"paths"`
 
-	Protection struct {
-		AdminTable   bool `yaml:"admin_table"`
-		PaymentTable bool `yaml:"payment_table"`
-	} `yaml:"protection"`
+	Protection ProtectionConfig `yaml:"protection"`
+
+	Audit AuditConfig `yaml:"audit"`
+
+	Logging LoggingConfig `yaml:"logging"`
+
+	Metrics MetricsConfig `yaml:"metrics"`
+}
+
+// LoggingConfig 配置结构化日志的输出方式，取代之前各保护协程各自 log.New 一个
+// 文件句柄的做法。
+type LoggingConfig struct {
+	// Level 是日志级别：debug、info、warn、error，默认 info。
+	Level string `yaml:"level"`
+	// Format 是日志格式：json 或 text，默认 text。
+	Format string `yaml:"format"`
+	// FilePath 是日志文件路径；为空时输出到标准错误。
+	FilePath string `yaml:"file_path"`
+	// MaxSizeMB 是日志文件轮转阈值（MB），超过后旧日志重命名为 .1 再继续写入；0 表示不轮转。
+	MaxSizeMB int `yaml:"max_size_mb"`
+}
+
+// MetricsConfig 配置 Prometheus 指标暴露端点。
+type MetricsConfig struct {
+	// ListenAddr 是 /metrics 的监听地址，例如 ":9090"；为空则不启动指标服务器。
+	ListenAddr string `yaml:"listen_addr"`
+}
+
+// AuditConfig 配置防篡改审计日志。
+type AuditConfig struct {
+	// Key 是审计日志哈希链的 HMAC 密钥，只存在于本配置文件中，不会写入日志本身。
+	Key string `yaml:"key"`
+	// LogPath 是审计日志文件路径。
+	LogPath string `yaml:"log_path"`
+}
+
+// ProtectionConfig 描述表保护功能的配置。
+type ProtectionConfig struct {
+	AdminTable   bool `yaml:"admin_table"`
+	PaymentTable bool `yaml:"payment_table"`
+
+	// UseBinlog 为 true 时，受保护的表改为基于 binlog 行事件实时回滚未授权变更，
+	// 而不是每 5 秒轮询全表。binlog 路径只支持 immutable 模式、单列主键且不带
+	// AllowedColumns 的规则；遇到其他规则（包括 AdminTable 推导出的 pinned_row
+	// 规则）时启动失败，这类表只能走轮询路径。
+	UseBinlog bool `yaml:"use_binlog"`
+	// BinlogServerID 是本消费者向 MySQL 注册的 slave server_id，
+	// 必须在整个复制拓扑中唯一。
+	BinlogServerID uint32 `yaml:"binlog_server_id"`
+	// BinlogCachePath 是保存已处理 binlog 位点的 BoltDB 文件路径，用于重启续传。
+	BinlogCachePath string `yaml:"binlog_cache_path"`
+	// AuthorizedMaintenanceGTIDs 列出可信运维操作对应的 GTID，
+	// 落在其中的事务不会被回滚；持久化基线不随之刷新，仍只能通过 trust-current 固化。
+	AuthorizedMaintenanceGTIDs []string `yaml:"authorized_maintenance_gtids"`
+
+	// Tables 声明受保护表的规则（轮询路径与 binlog 路径共用）。非空时取代 AdminTable/PaymentTable
+	// 中写死的 acg_manage/acg_pay 表名，使任意表都能在不重新编译的情况下被保护。
+	Tables []TableRule `yaml:"tables"`
+
+	// Store 配置基线快照的持久化后端，见 store.Config。
+	Store StoreConfig `yaml:"store"`
+}
+
+// StoreConfig 描述基线快照持久化后端的选择与参数。
+type StoreConfig struct {
+	// Type 是后端类型：file（默认）、bolt、redis。
+	Type string `yaml:"type"`
+	// FileDir 是 file 后端下基线快照 JSON 文件所在目录。
+	FileDir string `yaml:"file_dir"`
+	// BoltPath 是 bolt 后端下的 BoltDB 文件路径。
+	BoltPath string `yaml:"bolt_path"`
+	// RedisAddr/RedisPassword/RedisDB 是 redis 后端的连接参数。
+	RedisAddr     string `yaml:"redis_addr"`
+	RedisPassword string `yaml:"redis_password"`
+	RedisDB       int    `yaml:"redis_db"`
+
+	// Key 是基线快照的 HMAC 签名密钥，只存在于本配置文件中，不会随快照写入
+	// 存储本身，使攻击者即便直接拿到文件/BoltDB/Redis 的写权限，也无法伪造
+	// 出一份签名校验能通过的基线。
+	Key string `yaml:"key"`
+}
+
+// TableRule 描述一张受保护表的规则。
+type TableRule struct {
+	Database string `yaml:"database"`
+	Table    string `yaml:"table"`
+
+	// Mode 是保护模式：
+	//   append_only - 已有行不可修改/删除，允许新增
+	//   immutable   - 整表与基线逐行比对，新增/删除/修改一律还原
+	//   pinned_row  - 只保留满足 PinnedRowWhere 的行，其余一律删除
+	Mode string `yaml:"mode"`
+
+	// PinnedRowWhere 是 pinned_row 模式下用于保留记录的 SQL 条件，例如 "id = 1"。
+	PinnedRowWhere string `yaml:"pinned_row_where"`
+
+	// AllowedColumns 列出允许自由变更、不触发回滚的列名；为空表示所有列都受保护。
+	AllowedColumns []string `yaml:"allowed_columns"`
+
+	// AllowInsertsMatching 是一条 SQL 谓词（不含 WHERE 关键字），例如
+	// "status = 'pending' AND created_at > '2024-01-01'"。immutable 模式下，
+	// 新增记录若匹配该谓词则视为合法业务写入（如支付 webhook 插入的新订单），
+	// 予以保留而不回滚；为空表示维持原有行为，所有未在基线中的新增记录都会被删除。
+	// append_only 模式本就保留所有新增记录，该谓词不起作用。
+	AllowInsertsMatching string `yaml:"allow_inserts_matching"`
+
+	// PollIntervalSeconds 是该表的轮询间隔，默认 5 秒。
+	PollIntervalSeconds int `yaml:"poll_interval_seconds"`
 }
 
 // DefaultConfig returns the default configuration.
@@ -51,12 +155,22 @@ func DefaultConfig() *Config {
 			PluginOverrideSource: "",
 			PluginOverrideTarget: "",
 		},
-		Protection: struct {
-			AdminTable   bool `yaml:"admin_table"`
-			PaymentTable bool `yaml:"payment_table"`
-		}{
-			AdminTable:   true,
-			PaymentTable: true,
+		Protection: ProtectionConfig{
+			AdminTable:      true,
+			PaymentTable:    true,
+			UseBinlog:       false,
+			BinlogServerID:  1001,
+			BinlogCachePath: "acg_safeguard_baseline.db",
+		},
+		Audit: AuditConfig{
+			LogPath: "acg_safeguard_audit.log",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Metrics: MetricsConfig{
+			ListenAddr: ":9090",
 		},
 	}
 }
@@ -0,0 +1,118 @@
+// Package logging 从 config.yml 构造一个统一的结构化日志记录器，取代之前每个
+// 保护协程各自 log.New 一个文件句柄的做法，使级别、格式、轮转策略集中配置一处。
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Config 描述日志记录器的构造参数，字段与 config.LoggingConfig 一一对应。
+type Config struct {
+	// Level 是日志级别：debug、info、warn、error，默认 info。
+	Level string
+	// Format 是日志格式：json 或 text，默认 text。
+	Format string
+	// FilePath 是日志文件路径；为空时输出到标准错误。
+	FilePath string
+	// MaxSizeMB 是日志文件轮转阈值（MB），超过后旧日志重命名为 .1 再继续写入；0 表示不轮转。
+	MaxSizeMB int
+}
+
+// New 根据 cfg 构造一个 *slog.Logger。
+func New(cfg Config) (*slog.Logger, error) {
+	var writer io.Writer = os.Stderr
+	if cfg.FilePath != "" {
+		rw, err := newRotatingWriter(cfg.FilePath, cfg.MaxSizeMB)
+		if err != nil {
+			return nil, fmt.Errorf("打开日志文件失败: %w", err)
+		}
+		writer = rw
+	}
+
+	opts := &slog.HandlerOptions{Level: parseLevel(cfg.Level)}
+
+	var handler slog.Handler
+	if strings.EqualFold(cfg.Format, "json") {
+		handler = slog.NewJSONHandler(writer, opts)
+	} else {
+		handler = slog.NewTextHandler(writer, opts)
+	}
+	return slog.New(handler), nil
+}
+
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// rotatingWriter 是一个按大小轮转的 io.Writer：当写入会让文件超过 maxBytes 时，
+// 先把当前文件重命名为 path+".1"（覆盖上一份），再从头开始写入新文件。
+type rotatingWriter struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+func newRotatingWriter(path string, maxSizeMB int) (*rotatingWriter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotatingWriter{
+		path:     path,
+		maxBytes: int64(maxSizeMB) * 1024 * 1024,
+		file:     f,
+		size:     info.Size(),
+	}, nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxBytes > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("轮转日志文件失败: %w", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.size = 0
+	return nil
+}
@@ -4,20 +4,35 @@ import (
 	"crypto/md5"
 	"database/sql"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"reflect"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql" // MySQL driver
+	"github.com/hoshinonyaruko/acgv3-safeguard/binlog"
 	"github.com/hoshinonyaruko/acgv3-safeguard/config"
+	"github.com/hoshinonyaruko/acgv3-safeguard/logging"
+	"github.com/hoshinonyaruko/acgv3-safeguard/metrics"
+	"github.com/hoshinonyaruko/acgv3-safeguard/watcher"
 )
 
+// fileWatcherFullScanInterval 是 fsnotify 事件之外的全量扫描兜底周期。
+const fileWatcherFullScanInterval = 5 * time.Minute
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "trust-current" {
+		runTrustCurrent()
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "verify-log" {
+		runVerifyLog(os.Args[2])
+		return
+	}
+
 	// 配置文件路径
 	const configPath = "config.yml"
 
@@ -44,6 +59,21 @@ func main() {
 	// MySQL 连接成功
 	fmt.Println("成功连接到 MySQL 数据库")
 
+	// 结构化日志记录器，取代各保护协程各自 log.New 一个文件句柄的做法。
+	logger, err := logging.New(logging.Config{
+		Level:     cfg.Logging.Level,
+		Format:    cfg.Logging.Format,
+		FilePath:  cfg.Logging.FilePath,
+		MaxSizeMB: cfg.Logging.MaxSizeMB,
+	})
+	if err != nil {
+		log.Fatalf("初始化日志记录器失败: %v", err)
+	}
+
+	if err := metrics.StartServer(cfg.Metrics.ListenAddr, logger); err != nil {
+		logger.Error("启动 metrics 服务器失败", "error", err)
+	}
+
 	// 启动文件复写逻辑
 	if cfg.Paths.PayOverrideSource != "" && cfg.Paths.PayOverrideTarget != "" &&
 		cfg.Paths.PluginOverrideSource != "" && cfg.Paths.PluginOverrideTarget != "" {
@@ -54,33 +84,118 @@ func main() {
 		log.Println("复写目录配置未完成，跳过文件复写逻辑")
 	}
 
-	// 启动 MySQL 表保护逻辑
-	if cfg.Protection.AdminTable {
-		go protectAdminTable(cfg)
-	}
-
-	// 启动 PaymentTable 的保护逻辑
-	if cfg.Protection.PaymentTable {
-		go protectPaymentTable(cfg)
+	if cfg.Protection.UseBinlog {
+		// binlog 驱动的表保护：毫秒级发现未授权变更，而不是每 5 秒轮询一次。
+		go startBinlogProtection(cfg, logger)
+	} else {
+		// 轮询驱动的表保护，由 config.yml 中 protection.tables 声明的规则驱动。
+		go startTableProtection(cfg, logger)
 	}
 
 	select {} // 阻塞主线程，保持程序运行
 }
 
-// startFileOverrideRoutine 启动文件复写的协程
+// startFileOverrideRoutine 启动文件复写的协程。基于 fsnotify 监听 sourceDir，
+// 变更发生时只同步受影响的文件，而不是像之前那样每 5 秒对整个目录重新计算一次
+// MD5；同时保留一个较低频率的全量扫描作为兜底，避免 fsnotify 事件丢失
+// （例如 inotify 队列溢出、监听器重启）导致漏同步。
 func startFileOverrideRoutine(sourceDir, targetDir string) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	fullScan := func() {
+		if err := syncFiles(sourceDir, targetDir); err != nil {
+			log.Printf("全量同步错误: %v", err)
+		}
+	}
+	fullScan() // 启动时先做一次全量同步，确保两边起点一致
 
-	for range ticker.C {
-		err := syncFiles(sourceDir, targetDir)
-		if err != nil {
-			log.Printf("文件同步错误: %v", err)
+	w, err := watcher.New(watcher.Options{
+		SourceDir:        sourceDir,
+		FullScanInterval: fileWatcherFullScanInterval,
+		Logger:           log.Default(),
+		OnChange: func(path string) {
+			if err := syncSingleFile(sourceDir, targetDir, path); err != nil {
+				log.Printf("同步文件 %s 失败: %v", path, err)
+			}
+		},
+		OnRemove: func(path string) {
+			if err := removeSingleFile(sourceDir, targetDir, path); err != nil {
+				log.Printf("删除文件 %s 失败: %v", path, err)
+			}
+		},
+		OnFullScan: fullScan,
+	})
+	if err != nil {
+		log.Printf("初始化文件监听失败，退回每 5 秒轮询: %v", err)
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			fullScan()
+		}
+		return
+	}
+	defer w.Close()
+
+	if err := w.Run(); err != nil {
+		log.Printf("文件监听退出: %v", err)
+	}
+}
+
+// syncSingleFile 把 sourceDir 下单个发生变化的文件（或新建的子目录）同步到
+// targetDir 中的对应位置。
+func syncSingleFile(sourceDir, targetDir, srcPath string) error {
+	relPath, err := filepath.Rel(sourceDir, srcPath)
+	if err != nil {
+		return fmt.Errorf("无法计算文件的相对路径 %s: %w", srcPath, err)
+	}
+	targetPath := filepath.Join(targetDir, relPath)
+
+	info, err := os.Stat(srcPath)
+	if os.IsNotExist(err) {
+		return nil // 处理事件之前文件又被删除了，等待后续的删除事件处理
+	}
+	if err != nil {
+		return fmt.Errorf("无法获取源文件信息: %w", err)
+	}
+	if info.IsDir() {
+		return os.MkdirAll(targetPath, os.ModePerm)
+	}
+
+	if !shouldCopyFile(srcPath, targetPath) {
+		return nil
+	}
+
+	log.Printf("正在同步文件 %s 到 %s", srcPath, targetPath)
+	if err := os.MkdirAll(filepath.Dir(targetPath), os.ModePerm); err != nil {
+		return fmt.Errorf("无法创建目标文件夹 %s: %w", filepath.Dir(targetPath), err)
+	}
+	if err := copyFile(srcPath, targetPath); err != nil {
+		return err
+	}
+	metrics.FilesSynced.WithLabelValues(sourceDir).Inc()
+	return nil
+}
+
+// removeSingleFile 把 sourceDir 下被删除的文件从 targetDir 中的对应位置移除。
+func removeSingleFile(sourceDir, targetDir, srcPath string) error {
+	relPath, err := filepath.Rel(sourceDir, srcPath)
+	if err != nil {
+		return fmt.Errorf("无法计算文件的相对路径 %s: %w", srcPath, err)
+	}
+	targetPath := filepath.Join(targetDir, relPath)
+
+	if err := os.Remove(targetPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
 		}
+		return fmt.Errorf("无法删除文件 %s: %w", targetPath, err)
 	}
+	log.Printf("源文件 %s 已删除，已同步删除 %s", srcPath, targetPath)
+	return nil
 }
 
 func syncFiles(sourceDir, targetDir string) error {
+	start := time.Now()
+	defer func() { metrics.SyncDuration.Observe(time.Since(start).Seconds()) }()
+
 	sourceFiles, err := listFiles(sourceDir)
 	if err != nil {
 		return fmt.Errorf("无法列出源文件夹: %w", err)
@@ -112,6 +227,7 @@ func syncFiles(sourceDir, targetDir string) error {
 			if err := copyFile(srcFile, targetPath); err != nil {
 				return fmt.Errorf("无法复制文件 %s: %w", srcFile, err)
 			}
+			metrics.FilesSynced.WithLabelValues(sourceDir).Inc()
 		} else {
 			unchangedCount++
 		}
@@ -213,293 +329,152 @@ func listFiles(dir string) ([]string, error) {
 	return files, nil
 }
 
-// copyFile 复制文件，从 sourcePath 到 targetPath
+// copyFile 复制文件，从 sourcePath 到 targetPath。
+//
+// 为了不让读者（例如 PHP-FPM）看到只写了一半的目标文件，内容先完整写入
+// targetPath 同目录下的一个临时文件，写完后再整体 rename 过去——
+// rename 在同一文件系统内是原子操作，效果等价于 O_TMPFILE 创建匿名文件
+// 再落地链接的写时不可见语义。同时保留源文件的权限位和修改时间，
+// 避免复写后丢失可执行位导致 PHP-FPM 之类的场景出问题。
 func copyFile(sourcePath, targetPath string) error {
+	srcInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("无法获取源文件信息: %w", err)
+	}
+
 	sourceFile, err := os.Open(sourcePath)
 	if err != nil {
 		return fmt.Errorf("无法打开源文件: %w", err)
 	}
 	defer sourceFile.Close()
 
-	targetFile, err := os.Create(targetPath)
+	tmpFile, err := os.CreateTemp(filepath.Dir(targetPath), "."+filepath.Base(targetPath)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("无法创建目标文件: %w", err)
+		return fmt.Errorf("无法创建临时文件: %w", err)
 	}
-	defer targetFile.Close()
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath) // rename 成功后这里会因为文件已不存在而静默失败，属预期
 
-	if _, err := sourceFile.Seek(0, 0); err != nil {
-		return fmt.Errorf("无法重置源文件指针: %w", err)
-	}
-	if _, err := targetFile.ReadFrom(sourceFile); err != nil {
-		return fmt.Errorf("无法写入目标文件: %w", err)
+	if _, err := io.Copy(tmpFile, sourceFile); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("无法写入临时文件: %w", err)
 	}
-
-	return nil
-}
-
-// protectAdminTable 周期性删除 acg_manage 表中 id != 1 的行，并记录日志
-func protectAdminTable(cfg *config.Config) {
-	// 配置日志
-	logFile, err := os.OpenFile("acg_manage_cleanup.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("无法创建日志文件: %v", err)
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("无法刷新临时文件: %w", err)
 	}
-	defer logFile.Close()
-
-	logger := log.New(logFile, "", log.LstdFlags|log.Lshortfile)
-
-	// 数据库连接配置
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True",
-		cfg.MySQL.Username, cfg.MySQL.Password, cfg.MySQL.Address, "faka") // 替换 "faka" 为实际数据库名称
-
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		logger.Fatalf("数据库连接失败: %v", err)
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("无法关闭临时文件: %w", err)
 	}
-	defer db.Close()
-
-	for {
-		// 查询 `id != 1` 的所有行
-		rows, err := db.Query("SELECT * FROM acg_manage WHERE id != 1;")
-		if err != nil {
-			logger.Printf("查询失败: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
 
-		var rowsToDelete []map[string]interface{}
-		cols, _ := rows.Columns()
-		for rows.Next() {
-			row := make(map[string]interface{})
-			columnPointers := make([]interface{}, len(cols))
-			for i := range columnPointers {
-				columnPointers[i] = new(interface{})
-			}
-
-			if err := rows.Scan(columnPointers...); err != nil {
-				logger.Printf("扫描行失败: %v", err)
-				continue
-			}
-
-			for i, colName := range cols {
-				row[colName] = *(columnPointers[i].(*interface{}))
-			}
-			rowsToDelete = append(rowsToDelete, row)
-		}
-		rows.Close()
-
-		if len(rowsToDelete) > 0 {
-			// 记录删除的行
-			logDeletion(rowsToDelete, logger)
-
-			// 删除 `id != 1` 的行
-			_, err := db.Exec("DELETE FROM acg_manage WHERE id != 1;")
-			if err != nil {
-				logger.Printf("删除失败: %v", err)
-			} else {
-				logger.Printf("成功删除 %d 行", len(rowsToDelete))
-			}
-		} else {
-			logger.Println("未发现新增管理员")
-		}
-
-		time.Sleep(5 * time.Second)
+	if err := os.Chmod(tmpPath, srcInfo.Mode()); err != nil {
+		return fmt.Errorf("无法设置文件权限: %w", err)
 	}
-}
-
-// logDeletion 将删除的行记录到日志
-func logDeletion(deletedRows []map[string]interface{}, logger *log.Logger) {
-	for _, row := range deletedRows {
-		rowJSON, err := json.Marshal(row)
-		if err != nil {
-			logger.Printf("无法序列化行数据: %v", err)
-			continue
-		}
-		logger.Printf("删除的行: %s", rowJSON)
+	if err := os.Chtimes(tmpPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		return fmt.Errorf("无法设置文件修改时间: %w", err)
 	}
-}
 
-// protectPaymentTable 保护 acg_pay 表，防止新增、删除、修改
-func protectPaymentTable(cfg *config.Config) {
-	// 配置日志
-	logFile, err := os.OpenFile("acg_pay_protection.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("无法创建日志文件: %v", err)
+	if err := os.Rename(tmpPath, targetPath); err != nil {
+		return fmt.Errorf("无法重命名临时文件: %w", err)
 	}
-	defer logFile.Close()
 
-	logger := log.New(logFile, "", log.LstdFlags|log.Lshortfile)
+	return nil
+}
 
-	// 数据库连接配置
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=True",
-		cfg.MySQL.Username, cfg.MySQL.Password, cfg.MySQL.Address, "faka") // 替换 "faka" 为实际数据库名称
+// startBinlogProtection 启动基于 binlog 行事件的表保护，替代轮询式的 protectTable。
+func startBinlogProtection(cfg *config.Config, logger *slog.Logger) {
+	// 不在 DSN 里选定单一数据库：Protection.Tables[].Database 可以各不相同（见
+	// protectionRules），回滚时的 DML 一律用 database.table 形式显式限定。
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/?charset=utf8mb4&parseTime=True",
+		cfg.MySQL.Username, cfg.MySQL.Password, cfg.MySQL.Address)
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		logger.Fatalf("数据库连接失败: %v", err)
+		logger.Error("数据库连接失败", "error", err)
+		os.Exit(1)
 	}
 	defer db.Close()
 
-	// 获取初始状态
-	initialState, err := fetchTableState(db, "acg_pay")
-	if err != nil {
-		logger.Fatalf("获取初始状态失败: %v", err)
-	}
-	logger.Println("成功加载初始状态")
-
-	for {
-		currentState, err := fetchTableState(db, "acg_pay")
-		if err != nil {
-			logger.Printf("获取当前状态失败: %v", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		// 检查和还原表状态
-		err = restoreTableState(db, "acg_pay", initialState, currentState, logger)
-		if err != nil {
-			logger.Printf("还原表状态失败: %v", err)
-		}
-
-		time.Sleep(5 * time.Second)
-	}
-}
-
-// fetchTableState 获取表的完整状态
-func fetchTableState(db *sql.DB, tableName string) ([]map[string]interface{}, error) {
-	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s;", tableName))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	cols, _ := rows.Columns()
-	var state []map[string]interface{}
-
-	for rows.Next() {
-		row := make(map[string]interface{})
-		columnPointers := make([]interface{}, len(cols))
-		for i := range columnPointers {
-			columnPointers[i] = new(interface{})
+	var tables []binlog.TableConfig
+	for _, rule := range protectionRules(cfg) {
+		// binlog 消费者只实现了 immutable 模式的语义（见 binlog.TableConfig 的
+		// 文档注释）：append_only 需要放行新增、pinned_row 是完全不同的"只保留
+		// 匹配行"语义，两者都无法安全地退化成整表逐行回滚。与其静默忽略
+		// Mode，不如在启动时直接拒绝，逼迫这类表改用轮询路径（use_binlog: false）。
+		if rule.Mode != "" && rule.Mode != "immutable" {
+			logger.Error("use_binlog 模式不支持该表的保护模式，请改用轮询路径",
+				"database", rule.Database, "table", rule.Table, "mode", rule.Mode)
+			os.Exit(1)
 		}
-
-		if err := rows.Scan(columnPointers...); err != nil {
-			return nil, err
+		if len(rule.AllowedColumns) > 0 {
+			logger.Error("use_binlog 模式暂不支持 AllowedColumns，请改用轮询路径",
+				"database", rule.Database, "table", rule.Table)
+			os.Exit(1)
 		}
 
-		for i, colName := range cols {
-			row[colName] = *(columnPointers[i].(*interface{}))
+		pkColumns, err := fetchPrimaryKeyColumns(db, rule.Database, rule.Table)
+		if err != nil || len(pkColumns) == 0 {
+			metrics.DBErrors.Inc()
+			logger.Error("读取表主键失败", "database", rule.Database, "table", rule.Table, "error", err)
+			os.Exit(1)
 		}
-		state = append(state, row)
-	}
-	return state, nil
-}
-
-func restoreTableState(db *sql.DB, tableName string, initialState, currentState []map[string]interface{}, logger *log.Logger) error {
-	initialMap := sliceToMap(initialState)
-	currentMap := sliceToMap(currentState)
-
-	// 开始事务
-	tx, err := db.Begin()
-	if err != nil {
-		return fmt.Errorf("启动事务失败: %w", err)
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-	}()
-
-	// 检查新增记录
-	for key, currentRow := range currentMap {
-		if _, exists := initialMap[key]; !exists {
-			// 删除新增记录
-			logger.Printf("发现新增记录: %v", currentRow)
-			query, params := generateDeleteQuery(tableName, currentRow)
-			if _, err := tx.Exec(query, params...); err != nil {
-				return fmt.Errorf("删除新增记录失败: %w", err)
-			}
+		// binlog 消费者目前仅支持单列主键：rollbackInsert/rollbackUpdate
+		// 都用 "WHERE pkColumns[0] = ?" 定位行，复合主键下第一列可能不唯一，
+		// 静默只取 pkColumns[0] 会导致回滚语句打中/删掉错误的行。与其这样，
+		// 不如在启动时直接拒绝，逼迫这类表改用支持复合主键的轮询路径。
+		if len(pkColumns) > 1 {
+			logger.Error("use_binlog 模式暂不支持复合主键，请改用轮询路径",
+				"database", rule.Database, "table", rule.Table, "pk_columns", pkColumns)
+			os.Exit(1)
 		}
+		tables = append(tables, binlog.TableConfig{
+			Database:             rule.Database,
+			Table:                rule.Table,
+			PKColumn:             pkColumns[0],
+			AllowInsertsMatching: rule.AllowInsertsMatching,
+		})
 	}
-
-	// 检查删除记录
-	for key, initialRow := range initialMap {
-		if _, exists := currentMap[key]; !exists {
-			// 还原删除记录
-			query, params := generateInsertQuery(tableName, initialRow)
-			logger.Printf("还原删除记录: %v", initialRow)
-			if _, err := tx.Exec(query, params...); err != nil {
-				return fmt.Errorf("还原删除记录失败: %w", err)
-			}
-		}
+	if len(tables) == 0 {
+		logger.Info("未配置任何受保护的表，binlog 消费者不启动")
+		return
 	}
 
-	// 检查修改记录
-	for key, initialRow := range initialMap {
-		if currentRow, exists := currentMap[key]; exists {
-			if !isRowEqual(initialRow, currentRow) {
-				// 还原修改记录
-				logger.Printf("发现被修改的记录: %v", currentRow)
-				query, params := generateUpdateQuery(tableName, initialRow)
-				if _, err := tx.Exec(query, params...); err != nil {
-					return fmt.Errorf("还原修改记录失败: %w", err)
-				}
-			}
-		}
+	auditLogger, err := newAuditLogger(cfg)
+	if err != nil {
+		logger.Error("初始化审计日志失败", "error", err)
+		os.Exit(1)
 	}
+	defer auditLogger.Close()
 
-	return nil
-}
-
-func generateDeleteQuery(tableName string, row map[string]interface{}) (string, []interface{}) {
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?;", tableName)
-	return query, []interface{}{row["id"]}
-}
-
-func generateInsertQuery(tableName string, row map[string]interface{}) (string, []interface{}) {
-	columns := ""
-	placeholders := ""
-	values := []interface{}{}
-	for col, val := range row {
-		columns += fmt.Sprintf("%s, ", col)
-		placeholders += "?, "
-		values = append(values, val)
-	}
-	columns = columns[:len(columns)-2]
-	placeholders = placeholders[:len(placeholders)-2]
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", tableName, columns, placeholders)
-	return query, values
-}
-
-func generateUpdateQuery(tableName string, row map[string]interface{}) (string, []interface{}) {
-	query := fmt.Sprintf("UPDATE %s SET ", tableName)
-	params := []interface{}{}
-	for col, val := range row {
-		query += fmt.Sprintf("%s = ?, ", col)
-		params = append(params, val)
-	}
-	query = query[:len(query)-2]
-	query += " WHERE id = ?;"
-	params = append(params, row["id"])
-	return query, params
-}
-
-func isRowEqual(row1, row2 map[string]interface{}) bool {
-	for k, v1 := range row1 {
-		if v2, ok := row2[k]; !ok || !reflect.DeepEqual(v1, v2) {
-			return false
-		}
+	// baselineStore 与轮询路径（startTableProtection）共用同一套持久化基线，
+	// 只在进程重启且没有可恢复的 binlog 位点时只读加载一次，用来核对停机期间
+	// 是否发生了未授权变更；binlog 路径本身不会调用 Save，基线只能通过
+	// trust-current 显式固化，语义与轮询路径保持一致。
+	baselineStore, err := newBaselineStore(cfg)
+	if err != nil {
+		logger.Error("初始化基线存储失败", "error", err)
+		os.Exit(1)
+	}
+
+	watcher, err := binlog.NewWatcher(binlog.Config{
+		Address:         cfg.MySQL.Address,
+		Username:        cfg.MySQL.Username,
+		Password:        cfg.MySQL.Password,
+		ServerID:        cfg.Protection.BinlogServerID,
+		Tables:          tables,
+		CachePath:       cfg.Protection.BinlogCachePath,
+		AuthorizedGTIDs: cfg.Protection.AuthorizedMaintenanceGTIDs,
+		BaselineStore:   baselineStore,
+	}, db, logger, auditLogger)
+	if err != nil {
+		logger.Error("创建 binlog 消费者失败", "error", err)
+		os.Exit(1)
 	}
-	return true
-}
+	defer watcher.Close()
 
-// sliceToMap 将表状态转换为以 id 为键的 map
-func sliceToMap(slice []map[string]interface{}) map[interface{}]map[string]interface{} {
-	result := make(map[interface{}]map[string]interface{})
-	for _, row := range slice {
-		result[row["id"]] = row
+	logger.Info("binlog 消费者启动，开始订阅行事件")
+	if err := watcher.Run(); err != nil {
+		logger.Error("binlog 消费者退出", "error", err)
+		os.Exit(1)
 	}
-	return result
 }
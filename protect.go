@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/acgv3-safeguard/audit"
+	"github.com/hoshinonyaruko/acgv3-safeguard/config"
+	"github.com/hoshinonyaruko/acgv3-safeguard/metrics"
+	"github.com/hoshinonyaruko/acgv3-safeguard/store"
+)
+
+const defaultDatabase = "faka" // 替换为实际数据库名称
+const defaultPollInterval = 5 * time.Second
+
+// protectionRules 返回受保护表的规则（轮询路径与 binlog 路径共用）。若 cfg.Protection.Tables 非空则直接使用，
+// 否则根据 AdminTable/PaymentTable 两个开关推导出旧版硬编码的规则，保持向后兼容。
+func protectionRules(cfg *config.Config) []config.TableRule {
+	if len(cfg.Protection.Tables) > 0 {
+		return cfg.Protection.Tables
+	}
+
+	var rules []config.TableRule
+	if cfg.Protection.AdminTable {
+		rules = append(rules, config.TableRule{
+			Database:       defaultDatabase,
+			Table:          "acg_manage",
+			Mode:           "pinned_row",
+			PinnedRowWhere: "id = 1",
+		})
+	}
+	if cfg.Protection.PaymentTable {
+		rules = append(rules, config.TableRule{
+			Database: defaultDatabase,
+			Table:    "acg_pay",
+			Mode:     "immutable",
+		})
+	}
+	return rules
+}
+
+// newBaselineStore 根据配置构造基线存储后端。
+func newBaselineStore(cfg *config.Config) (store.BaselineStore, error) {
+	return store.New(store.Config{
+		Type:          cfg.Protection.Store.Type,
+		FileDir:       cfg.Protection.Store.FileDir,
+		BoltPath:      cfg.Protection.Store.BoltPath,
+		RedisAddr:     cfg.Protection.Store.RedisAddr,
+		RedisPassword: cfg.Protection.Store.RedisPassword,
+		RedisDB:       cfg.Protection.Store.RedisDB,
+		Key:           cfg.Protection.Store.Key,
+	})
+}
+
+// newAuditLogger 根据配置构造防篡改审计日志记录器。
+func newAuditLogger(cfg *config.Config) (*audit.Logger, error) {
+	return audit.NewLogger(cfg.Audit.LogPath, []byte(cfg.Audit.Key))
+}
+
+// startTableProtection 为每条规则各启动一个轮询协程。
+func startTableProtection(cfg *config.Config, logger *slog.Logger) {
+	rules := protectionRules(cfg)
+	if len(rules) == 0 {
+		logger.Info("未配置任何受保护的表，表保护逻辑不启动")
+		return
+	}
+
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/?charset=utf8mb4&parseTime=True",
+		cfg.MySQL.Username, cfg.MySQL.Password, cfg.MySQL.Address)
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Fatalf("数据库连接失败: %v", err)
+	}
+
+	baselineStore, err := newBaselineStore(cfg)
+	if err != nil {
+		log.Fatalf("初始化基线存储失败: %v", err)
+	}
+
+	auditLogger, err := newAuditLogger(cfg)
+	if err != nil {
+		log.Fatalf("初始化审计日志失败: %v", err)
+	}
+
+	for _, rule := range rules {
+		go protectTable(db, rule, baselineStore, auditLogger, logger)
+	}
+}
+
+// runTrustCurrent 实现 `acg-safeguard trust-current` 子命令：把受保护表的当前状态
+// 固化为新的可信基线。只应在确认当前数据库状态合法时手动执行。
+func runTrustCurrent() {
+	cfg, err := config.LoadConfig("config.yml")
+	if err != nil {
+		log.Fatalf("加载配置文件失败: %v", err)
+	}
+
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/?charset=utf8mb4&parseTime=True",
+		cfg.MySQL.Username, cfg.MySQL.Password, cfg.MySQL.Address)
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Fatalf("无法连接到 MySQL 数据库: %v", err)
+	}
+	defer db.Close()
+
+	baselineStore, err := newBaselineStore(cfg)
+	if err != nil {
+		log.Fatalf("初始化基线存储失败: %v", err)
+	}
+
+	auditLogger, err := newAuditLogger(cfg)
+	if err != nil {
+		log.Fatalf("初始化审计日志失败: %v", err)
+	}
+	defer auditLogger.Close()
+
+	for _, rule := range protectionRules(cfg) {
+		if rule.Mode == "pinned_row" {
+			continue // pinned_row 模式不依赖持久化基线
+		}
+
+		qualifiedTable := fmt.Sprintf("%s.%s", rule.Database, rule.Table)
+		state, err := fetchTableState(db, qualifiedTable)
+		if err != nil {
+			log.Fatalf("获取 %s 当前状态失败: %v", qualifiedTable, err)
+		}
+		if err := baselineStore.Save(qualifiedTable, state); err != nil {
+			log.Fatalf("保存 %s 基线失败: %v", qualifiedTable, err)
+		}
+		if err := auditLogger.Append("trust_current", map[string]interface{}{"table": qualifiedTable, "rows": len(state)}); err != nil {
+			log.Fatalf("写入审计日志失败: %v", err)
+		}
+		fmt.Printf("已将 %s 的当前状态设为可信基线（%d 行）\n", qualifiedTable, len(state))
+	}
+}
+
+// runVerifyLog 实现 `acg-safeguard verify-log <file>` 子命令：重放审计日志的哈希链，
+// 报告第一处被篡改或删除的记录所在行号。
+func runVerifyLog(path string) {
+	cfg, err := config.LoadConfig("config.yml")
+	if err != nil {
+		log.Fatalf("加载配置文件失败: %v", err)
+	}
+
+	badLine, err := audit.VerifyFile(path, []byte(cfg.Audit.Key))
+	if err != nil {
+		log.Fatalf("校验审计日志失败: %v", err)
+	}
+	if badLine == 0 {
+		fmt.Printf("审计日志 %s 哈希链完整，未发现篡改\n", path)
+		return
+	}
+	fmt.Printf("审计日志 %s 在第 %d 行哈希校验失败，此行及之后的记录可能被篡改\n", path, badLine)
+	os.Exit(1)
+}
+
+// protectTable 是表保护的通用驱动：读取规则指定表的主键，
+// 按规则的模式周期性地将表状态与基线比对并回滚未授权变更。
+func protectTable(db *sql.DB, rule config.TableRule, baselineStore store.BaselineStore, auditLogger *audit.Logger, logger *slog.Logger) {
+	logger = logger.With("database", rule.Database, "table", rule.Table)
+
+	pkColumns, err := fetchPrimaryKeyColumns(db, rule.Database, rule.Table)
+	if err != nil {
+		metrics.DBErrors.Inc()
+		logger.Error("读取主键列失败", "error", err)
+		os.Exit(1)
+	}
+	if len(pkColumns) == 0 {
+		logger.Error("表没有主键，无法保护")
+		os.Exit(1)
+	}
+
+	qualifiedTable := fmt.Sprintf("%s.%s", rule.Database, rule.Table)
+	interval := defaultPollInterval
+	if rule.PollIntervalSeconds > 0 {
+		interval = time.Duration(rule.PollIntervalSeconds) * time.Second
+	}
+
+	switch rule.Mode {
+	case "pinned_row":
+		runPinnedRowProtection(db, qualifiedTable, rule, logger, interval, auditLogger)
+	case "append_only", "immutable":
+		runBaselineProtection(db, qualifiedTable, rule, pkColumns, logger, interval, baselineStore, auditLogger)
+	default:
+		logger.Error("未知的保护模式", "mode", rule.Mode)
+		os.Exit(1)
+	}
+}
+
+// runPinnedRowProtection 只保留满足 PinnedRowWhere 的行，其余一律删除。
+func runPinnedRowProtection(db *sql.DB, qualifiedTable string, rule config.TableRule, logger *slog.Logger, interval time.Duration, auditLogger *audit.Logger) {
+	for {
+		rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s WHERE NOT (%s);", qualifiedTable, rule.PinnedRowWhere))
+		if err != nil {
+			metrics.DBErrors.Inc()
+			logger.Error("查询失败", "error", err)
+			time.Sleep(interval)
+			continue
+		}
+
+		rowsToDelete, err := scanRows(rows)
+		rows.Close()
+		if err != nil {
+			logger.Error("扫描行失败", "error", err)
+			time.Sleep(interval)
+			continue
+		}
+
+		if len(rowsToDelete) > 0 {
+			logDeletion(rowsToDelete, logger)
+			for _, row := range rowsToDelete {
+				if err := auditLogger.Append("unauthorized_insert", row); err != nil {
+					logger.Error("写入审计日志失败", "error", err)
+				}
+			}
+			if _, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE NOT (%s);", qualifiedTable, rule.PinnedRowWhere)); err != nil {
+				metrics.DBErrors.Inc()
+				logger.Error("删除失败", "error", err)
+			} else {
+				metrics.RowsRestored.WithLabelValues(qualifiedTable, "insert").Add(float64(len(rowsToDelete)))
+				logger.Info("成功删除未授权新增行", "count", len(rowsToDelete))
+			}
+		} else {
+			logger.Debug("未发现未授权的新增行")
+		}
+
+		metrics.LastSuccessfulScan.WithLabelValues(qualifiedTable).SetToCurrentTime()
+		time.Sleep(interval)
+	}
+}
+
+// runBaselineProtection 将表的当前状态与基线逐行比对，按 append_only/immutable 语义回滚未授权变更。
+//
+// 基线优先从 baselineStore 中加载持久化的可信快照；只有在从未保存过基线时，
+// 才退化为用当前表状态临时引导（并提示需要运行 trust-current 才能持久化），
+// 这样攻破数据库的攻击者无法靠重启进程把自己的改动变成新基线。
+func runBaselineProtection(db *sql.DB, qualifiedTable string, rule config.TableRule, pkColumns []string, logger *slog.Logger, interval time.Duration, baselineStore store.BaselineStore, auditLogger *audit.Logger) {
+	initialState, err := baselineStore.Load(qualifiedTable)
+	if err != nil {
+		logger.Error("加载持久化基线失败", "error", err)
+		os.Exit(1)
+	}
+	if initialState != nil {
+		logger.Info("成功加载持久化的可信基线")
+	} else {
+		initialState, err = fetchTableState(db, qualifiedTable)
+		if err != nil {
+			logger.Error("获取初始状态失败", "error", err)
+			os.Exit(1)
+		}
+		logger.Warn("未找到持久化基线，临时以当前表状态引导；请执行 trust-current 固化基线")
+	}
+
+	for {
+		if err := restoreTableState(db, qualifiedTable, rule, pkColumns, initialState, logger, baselineStore, auditLogger); err != nil {
+			logger.Error("还原表状态失败", "error", err)
+		} else {
+			metrics.LastSuccessfulScan.WithLabelValues(qualifiedTable).SetToCurrentTime()
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// fetchPrimaryKeyColumns 从 INFORMATION_SCHEMA 读取表的主键列，按声明顺序返回。
+func fetchPrimaryKeyColumns(db *sql.DB, database, table string) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE
+		 WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY'
+		 ORDER BY ORDINAL_POSITION;`, database, table)
+	if err != nil {
+		return nil, fmt.Errorf("查询主键列失败: %w", err)
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// rowKey 把主键列的值拼接成一个可比较的 map 键。
+func rowKey(row map[string]interface{}, pkColumns []string) string {
+	parts := make([]string, len(pkColumns))
+	for i, col := range pkColumns {
+		parts[i] = fmt.Sprintf("%v", row[col])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// logDeletion 将删除的行记录到日志
+func logDeletion(deletedRows []map[string]interface{}, logger *slog.Logger) {
+	for _, row := range deletedRows {
+		rowJSON, err := json.Marshal(row)
+		if err != nil {
+			logger.Error("无法序列化行数据", "error", err)
+			continue
+		}
+		logger.Info("删除未授权新增行", "row", string(rowJSON))
+	}
+}
+
+// scanRows 把查询结果的每一行读成一个 map[列名]值。
+func scanRows(rows *sql.Rows) ([]map[string]interface{}, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		row := make(map[string]interface{})
+		columnPointers := make([]interface{}, len(cols))
+		for i := range columnPointers {
+			columnPointers[i] = new(interface{})
+		}
+
+		if err := rows.Scan(columnPointers...); err != nil {
+			return nil, err
+		}
+
+		for i, colName := range cols {
+			row[colName] = *(columnPointers[i].(*interface{}))
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// fetchTableState 获取表的完整状态，不加锁。仅用于 trust-current 固化基线、
+// 以及尚未持久化基线时的一次性引导读取，不用于回滚前的比对（见 fetchTableStateForUpdate）。
+func fetchTableState(db *sql.DB, qualifiedTable string) ([]map[string]interface{}, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s;", qualifiedTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRows(rows)
+}
+
+// fetchRowsForUpdate 在事务内只对 candidates 里列出的主键对应的行加 FOR UPDATE 锁，
+// 而不是像最初的实现那样对整张表不带 WHERE 条件地加锁——那会在每次轮询时对全表
+// 所有行和间隙加 next-key lock，把真实的并发写入（例如支付 webhook 的 INSERT）
+// 挡在整个比对+回滚过程之外。这里按主键做等值查找，锁范围收窄到候选行本身
+// （及其相邻间隙），不相关的行可以照常被其他事务写入。
+func fetchRowsForUpdate(tx *sql.Tx, qualifiedTable string, pkColumns []string, candidates []map[string]interface{}) ([]map[string]interface{}, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	where, params := pkInClause(pkColumns, candidates)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s FOR UPDATE;", qualifiedTable, where)
+	rows, err := tx.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRows(rows)
+}
+
+// pkInClause 为一批候选行生成 "pk IN (?, ?, ...)"（单列主键）或
+// "(pk1, pk2) IN ((?, ?), ...)"（复合主键）形式的谓词。
+func pkInClause(pkColumns []string, rows []map[string]interface{}) (string, []interface{}) {
+	col := strings.Join(pkColumns, ", ")
+	mark := "?"
+	if len(pkColumns) > 1 {
+		col = "(" + col + ")"
+		marks := make([]string, len(pkColumns))
+		for i := range marks {
+			marks[i] = "?"
+		}
+		mark = "(" + strings.Join(marks, ", ") + ")"
+	}
+
+	tuples := make([]string, len(rows))
+	params := make([]interface{}, 0, len(rows)*len(pkColumns))
+	for i, row := range rows {
+		tuples[i] = mark
+		for _, pk := range pkColumns {
+			params = append(params, row[pk])
+		}
+	}
+	return fmt.Sprintf("%s IN (%s)", col, strings.Join(tuples, ", ")), params
+}
+
+// rowMatchesPredicate 在事务内对 predicate（例如 AllowInsertsMatching 配置的
+// "status = 'pending' AND created_at > '2024-01-01'"）重新求值，判断 row 是否匹配。
+// 交给 MySQL 求值而不是在 Go 端重新实现 SQL 表达式语义，避免两边解释不一致。
+func rowMatchesPredicate(tx *sql.Tx, qualifiedTable string, pkColumns []string, row map[string]interface{}, predicate string) (bool, error) {
+	where, params := pkWhereClause(pkColumns, row)
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE %s AND (%s) LIMIT 1;", qualifiedTable, where, predicate)
+
+	var exists int
+	err := tx.QueryRow(query, params...).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("校验 AllowInsertsMatching 条件失败: %w", err)
+	}
+	return true, nil
+}
+
+// restoreTableState 在一个 REPEATABLE READ 事务内对候选行 SELECT ... FOR UPDATE 锁定，
+// 与可信基线比对后原子地回滚未授权变更、提交或整体回滚。
+//
+// 之所以要在事务内加锁读取当前状态，而不是像最初那样在事务外 fetchTableState 一次，
+// 是为了堵上一个窗口期：如果在“读到当前状态”和“执行回滚”之间，一笔合法的业务写入
+// （例如支付 webhook 插入的新订单）恰好发生，旧实现会把它当成未授权新增一并删除，
+// 造成误杀；现在这笔行在加锁读取时要么已经可见并参与比对，要么根本还没提交。
+//
+// 加锁前先在事务外做一次不加锁的全表扫描，只用它确定这一轮需要核对哪些主键
+// （基线中的所有行 + 当前表中出现的所有行），再对这个候选集合做 FOR UPDATE。
+// 这样锁范围收窄到候选行本身，不会像最初那样对整张表的所有行和间隙加
+// next-key lock、把无关的并发写入也一并挡住。代价是：如果一笔全新的 INSERT
+// 恰好发生在“不加锁扫描”和“加锁核对”这两步之间，这一轮会漏掉它，但会在
+// 下一次轮询（默认 5 秒后）里被发现并按规则处理，不会被放过。
+func restoreTableState(db *sql.DB, qualifiedTable string, rule config.TableRule, pkColumns []string, initialState []map[string]interface{}, logger *slog.Logger, baselineStore store.BaselineStore, auditLogger *audit.Logger) error {
+	initialMap := sliceToMap(initialState, pkColumns)
+
+	snapshot, err := fetchTableState(db, qualifiedTable)
+	if err != nil {
+		return fmt.Errorf("扫描当前状态失败: %w", err)
+	}
+
+	candidates := make(map[string]map[string]interface{}, len(initialMap)+len(snapshot))
+	for key, row := range initialMap {
+		candidates[key] = row
+	}
+	for _, row := range snapshot {
+		candidates[rowKey(row, pkColumns)] = row
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	candidateRows := make([]map[string]interface{}, 0, len(candidates))
+	for _, row := range candidates {
+		candidateRows = append(candidateRows, row)
+	}
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return fmt.Errorf("启动事务失败: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+
+	currentState, err := fetchRowsForUpdate(tx, qualifiedTable, pkColumns, candidateRows)
+	if err != nil {
+		err = fmt.Errorf("加锁读取候选行失败: %w", err)
+		return err
+	}
+	currentMap := sliceToMap(currentState, pkColumns)
+
+	// append_only 模式允许新增，其余模式新增记录一律删除，除非匹配 AllowInsertsMatching。
+	if rule.Mode != "append_only" {
+		for key, currentRow := range currentMap {
+			if _, exists := initialMap[key]; exists {
+				continue
+			}
+
+			if rule.AllowInsertsMatching != "" {
+				matches, matchErr := rowMatchesPredicate(tx, qualifiedTable, pkColumns, currentRow, rule.AllowInsertsMatching)
+				if matchErr != nil {
+					err = matchErr
+					return err
+				}
+				if matches {
+					logger.Info("新增记录匹配 AllowInsertsMatching，予以保留", "row", currentRow)
+					continue
+				}
+			}
+
+			logger.Warn("发现未授权新增记录", "row", currentRow)
+			recordDiff(baselineStore, auditLogger, qualifiedTable, "unauthorized_insert", currentRow)
+			query, params := generateDeleteQuery(qualifiedTable, pkColumns, currentRow)
+			if _, execErr := tx.Exec(query, params...); execErr != nil {
+				metrics.DBErrors.Inc()
+				err = fmt.Errorf("删除新增记录失败: %w", execErr)
+				return err
+			}
+			metrics.RowsRestored.WithLabelValues(qualifiedTable, "insert").Inc()
+		}
+	}
+
+	// 检查删除记录
+	for key, initialRow := range initialMap {
+		if _, exists := currentMap[key]; !exists {
+			recordDiff(baselineStore, auditLogger, qualifiedTable, "unauthorized_delete", initialRow)
+			query, params := generateInsertQuery(qualifiedTable, initialRow)
+			logger.Warn("还原未授权删除记录", "row", initialRow)
+			if execErr := execOrWrap(tx, query, params, "还原删除记录失败"); execErr != nil {
+				metrics.DBErrors.Inc()
+				err = execErr
+				return err
+			}
+			metrics.RowsRestored.WithLabelValues(qualifiedTable, "delete").Inc()
+		}
+	}
+
+	// 检查修改记录，AllowedColumns 中列出的列允许自由变更
+	for key, initialRow := range initialMap {
+		currentRow, exists := currentMap[key]
+		if !exists {
+			continue
+		}
+		if isRowEqual(initialRow, currentRow, rule.AllowedColumns) {
+			continue
+		}
+		logger.Warn("发现被未授权修改的记录", "row", currentRow)
+		recordDiff(baselineStore, auditLogger, qualifiedTable, "unauthorized_update", currentRow)
+		query, params := generateUpdateQuery(qualifiedTable, pkColumns, initialRow)
+		if execErr := execOrWrap(tx, query, params, "还原修改记录失败"); execErr != nil {
+			metrics.DBErrors.Inc()
+			err = execErr
+			return err
+		}
+		metrics.RowsRestored.WithLabelValues(qualifiedTable, "update").Inc()
+	}
+
+	return nil
+}
+
+// recordDiff 把发现的未授权变更记录下来，供事后审计：一份写入基线存储的
+// 差异日志（用于快速查看），另一份写入 auditLogger 的哈希链（用于防篡改取证）。
+func recordDiff(baselineStore store.BaselineStore, auditLogger *audit.Logger, qualifiedTable, event string, row map[string]interface{}) {
+	rowJSON, err := json.Marshal(row)
+	if err != nil {
+		return
+	}
+	_ = baselineStore.AppendDiff(qualifiedTable, fmt.Sprintf(`{"event":%q,"row":%s}`, event, rowJSON))
+	_ = auditLogger.Append(event, row)
+}
+
+func execOrWrap(tx *sql.Tx, query string, params []interface{}, context string) error {
+	if _, err := tx.Exec(query, params...); err != nil {
+		return fmt.Errorf("%s: %w", context, err)
+	}
+	return nil
+}
+
+func generateDeleteQuery(qualifiedTable string, pkColumns []string, row map[string]interface{}) (string, []interface{}) {
+	where, params := pkWhereClause(pkColumns, row)
+	return fmt.Sprintf("DELETE FROM %s WHERE %s;", qualifiedTable, where), params
+}
+
+func generateInsertQuery(qualifiedTable string, row map[string]interface{}) (string, []interface{}) {
+	columns := make([]string, 0, len(row))
+	placeholders := make([]string, 0, len(row))
+	values := make([]interface{}, 0, len(row))
+	for col, val := range row {
+		columns = append(columns, col)
+		placeholders = append(placeholders, "?")
+		values = append(values, val)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", qualifiedTable, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	return query, values
+}
+
+func generateUpdateQuery(qualifiedTable string, pkColumns []string, row map[string]interface{}) (string, []interface{}) {
+	isPK := make(map[string]bool, len(pkColumns))
+	for _, col := range pkColumns {
+		isPK[col] = true
+	}
+
+	assignments := make([]string, 0, len(row))
+	params := make([]interface{}, 0, len(row))
+	for col, val := range row {
+		if isPK[col] {
+			continue
+		}
+		assignments = append(assignments, col+" = ?")
+		params = append(params, val)
+	}
+
+	where, whereParams := pkWhereClause(pkColumns, row)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s;", qualifiedTable, strings.Join(assignments, ", "), where)
+	return query, append(params, whereParams...)
+}
+
+func pkWhereClause(pkColumns []string, row map[string]interface{}) (string, []interface{}) {
+	conditions := make([]string, len(pkColumns))
+	params := make([]interface{}, len(pkColumns))
+	for i, col := range pkColumns {
+		conditions[i] = col + " = ?"
+		params[i] = row[col]
+	}
+	return strings.Join(conditions, " AND "), params
+}
+
+// isRowEqual 比较两行是否一致；allowedColumns 中列出的列允许自由变更，不参与比较。
+func isRowEqual(row1, row2 map[string]interface{}, allowedColumns []string) bool {
+	allowed := make(map[string]bool, len(allowedColumns))
+	for _, col := range allowedColumns {
+		allowed[col] = true
+	}
+
+	for k, v1 := range row1 {
+		if allowed[k] {
+			continue
+		}
+		if v2, ok := row2[k]; !ok || !reflect.DeepEqual(v1, v2) {
+			return false
+		}
+	}
+	return true
+}
+
+// sliceToMap 将表状态转换为以主键为键的 map
+func sliceToMap(slice []map[string]interface{}, pkColumns []string) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{})
+	for _, row := range slice {
+		result[rowKey(row, pkColumns)] = row
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltBaselineBucket = []byte("baseline")
+	boltDiffBucket     = []byte("diff")
+)
+
+// BoltStore 把基线快照保存在一个嵌入式 BoltDB 文件里。
+// 快照内容带 HMAC 签名（见 signing.go），key 为空时等价于空密钥签名。
+type BoltStore struct {
+	db  *bolt.DB
+	key []byte
+}
+
+// NewBoltStore 打开（或创建）一个 BoltDB 基线存储。
+func NewBoltStore(path string, key []byte) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("打开 BoltDB 基线存储失败: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBaselineBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltDiffBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化 BoltDB 基线存储失败: %w", err)
+	}
+	return &BoltStore{db: db, key: key}, nil
+}
+
+// Load 实现 BaselineStore。
+func (s *BoltStore) Load(table string) ([]Row, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(boltBaselineBucket).Get([]byte(table)); raw != nil {
+			data = append([]byte(nil), raw...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+	rows, err := unmarshalSigned(s.key, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s 的基线校验失败: %w", table, err)
+	}
+	return rows, nil
+}
+
+// Save 实现 BaselineStore。
+func (s *BoltStore) Save(table string, rows []Row) error {
+	data, err := marshalSigned(s.key, rows)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBaselineBucket).Put([]byte(table), data)
+	})
+}
+
+// AppendDiff 实现 BaselineStore，差异以 "table" 为前缀、自增序号为键追加存储。
+func (s *BoltStore) AppendDiff(table string, diff string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltDiffBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s\x00%020d", table, seq)
+		return b.Put([]byte(key), []byte(diff))
+	})
+}
+
+// Close 释放底层 BoltDB 文件句柄。
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
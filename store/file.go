@@ -0,0 +1,73 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileStore 把每张表的基线快照保存为 Dir 下的一个 JSON 文件，差异追加到同名 .diff.log 文件。
+// 快照文件内容带 HMAC 签名（见 signing.go），key 为空时等价于空密钥签名。
+type FileStore struct {
+	Dir string
+	key []byte
+}
+
+// NewFileStore 创建一个基于本地 JSON 文件的 BaselineStore。
+func NewFileStore(dir string, key []byte) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建基线目录失败: %w", err)
+	}
+	return &FileStore{Dir: dir, key: key}, nil
+}
+
+func (s *FileStore) baselinePath(table string) string {
+	return filepath.Join(s.Dir, table+".json")
+}
+
+func (s *FileStore) diffLogPath(table string) string {
+	return filepath.Join(s.Dir, table+".diff.log")
+}
+
+// Load 实现 BaselineStore。
+func (s *FileStore) Load(table string) ([]Row, error) {
+	data, err := os.ReadFile(s.baselinePath(table))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取基线文件失败: %w", err)
+	}
+
+	rows, err := unmarshalSigned(s.key, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s 的基线文件校验失败: %w", table, err)
+	}
+	return rows, nil
+}
+
+// Save 实现 BaselineStore。
+func (s *FileStore) Save(table string, rows []Row) error {
+	data, err := marshalSigned(s.key, rows)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.baselinePath(table), data, 0644); err != nil {
+		return fmt.Errorf("写入基线文件失败: %w", err)
+	}
+	return nil
+}
+
+// AppendDiff 实现 BaselineStore。
+func (s *FileStore) AppendDiff(table string, diff string) error {
+	f, err := os.OpenFile(s.diffLogPath(table), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("打开差异日志失败: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(diff + "\n"); err != nil {
+		return fmt.Errorf("写入差异日志失败: %w", err)
+	}
+	return nil
+}
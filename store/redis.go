@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore 把基线快照保存在 Redis 里，便于多实例共享同一份基线。
+// 快照内容带 HMAC 签名（见 signing.go），key 为空时等价于空密钥签名。
+type RedisStore struct {
+	client *redis.Client
+	key    []byte
+}
+
+// NewRedisStore 创建一个连接到指定 Redis 实例的 BaselineStore。
+func NewRedisStore(addr, password string, db int, key []byte) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("连接 Redis 失败: %w", err)
+	}
+	return &RedisStore{client: client, key: key}, nil
+}
+
+func baselineKey(table string) string { return "acg_safeguard:baseline:" + table }
+func diffKey(table string) string     { return "acg_safeguard:diff:" + table }
+
+// Load 实现 BaselineStore。
+func (s *RedisStore) Load(table string) ([]Row, error) {
+	data, err := s.client.Get(context.Background(), baselineKey(table)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取 Redis 基线失败: %w", err)
+	}
+
+	rows, err := unmarshalSigned(s.key, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s 的 Redis 基线校验失败: %w", table, err)
+	}
+	return rows, nil
+}
+
+// Save 实现 BaselineStore。
+func (s *RedisStore) Save(table string, rows []Row) error {
+	data, err := marshalSigned(s.key, rows)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(context.Background(), baselineKey(table), data, 0).Err(); err != nil {
+		return fmt.Errorf("写入 Redis 基线失败: %w", err)
+	}
+	return nil
+}
+
+// AppendDiff 实现 BaselineStore。
+func (s *RedisStore) AppendDiff(table string, diff string) error {
+	if err := s.client.RPush(context.Background(), diffKey(table), diff).Err(); err != nil {
+		return fmt.Errorf("写入 Redis 差异日志失败: %w", err)
+	}
+	return nil
+}
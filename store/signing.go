@@ -0,0 +1,56 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// signedSnapshot 是基线快照落盘/落库时的实际编码格式：除了行数据本身，还带有
+// 对 Rows 计算的 HMAC-SHA256。key 只存在于 config.yml（由调用方传入），
+// 因此直接篡改底层文件/BoltDB/Redis 里的内容（而不经过本进程）无法伪造出
+// 一份仍能通过校验的基线，堵上了 chunk0-3 设计目标里"攻破数据库的攻击者
+// 无法悄悄改写基线"唯独没有覆盖到基线快照本身的那个口子。
+type signedSnapshot struct {
+	Rows []Row  `json:"rows"`
+	HMAC string `json:"hmac"`
+}
+
+// marshalSigned 把 rows 序列化为带 HMAC 的快照格式，供各后端写入时使用。
+func marshalSigned(key []byte, rows []Row) ([]byte, error) {
+	rowsJSON, err := json.Marshal(rows)
+	if err != nil {
+		return nil, fmt.Errorf("序列化基线失败: %w", err)
+	}
+	data, err := json.Marshal(signedSnapshot{Rows: rows, HMAC: computeSnapshotHMAC(key, rowsJSON)})
+	if err != nil {
+		return nil, fmt.Errorf("序列化基线签名失败: %w", err)
+	}
+	return data, nil
+}
+
+// unmarshalSigned 解析 marshalSigned 写入的快照，并校验其 HMAC；
+// 签名不匹配时返回错误而不是把内容当成可信基线静默放行。
+func unmarshalSigned(key []byte, data []byte) ([]Row, error) {
+	var snap signedSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("解析基线失败: %w", err)
+	}
+
+	rowsJSON, err := json.Marshal(snap.Rows)
+	if err != nil {
+		return nil, fmt.Errorf("重新序列化基线失败: %w", err)
+	}
+	if computeSnapshotHMAC(key, rowsJSON) != snap.HMAC {
+		return nil, fmt.Errorf("基线签名校验失败，快照可能已被直接篡改")
+	}
+	return snap.Rows, nil
+}
+
+func computeSnapshotHMAC(key []byte, rowsJSON []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(rowsJSON)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package store
+
+import "testing"
+
+func TestMarshalUnmarshalSigned(t *testing.T) {
+	key := []byte("test-key")
+	rows := []Row{
+		{"id": float64(1), "name": "a"},
+		{"id": float64(2), "name": "b"},
+	}
+
+	t.Run("正常签名后可以完整还原", func(t *testing.T) {
+		data, err := marshalSigned(key, rows)
+		if err != nil {
+			t.Fatalf("marshalSigned: %v", err)
+		}
+		got, err := unmarshalSigned(key, data)
+		if err != nil {
+			t.Fatalf("unmarshalSigned: %v", err)
+		}
+		if len(got) != len(rows) {
+			t.Fatalf("期望还原 %d 行，实际 %d 行", len(rows), len(got))
+		}
+	})
+
+	t.Run("快照内容被篡改后签名校验失败", func(t *testing.T) {
+		data, err := marshalSigned(key, rows)
+		if err != nil {
+			t.Fatalf("marshalSigned: %v", err)
+		}
+		tampered := make([]byte, len(data))
+		copy(tampered, data)
+		// 把快照里的行数据替换成另一份内容，但保留原有 HMAC 字段不变。
+		tampered = []byte(replaceRowsKeepHMAC(string(tampered), `"name":"a"`, `"name":"x"`))
+
+		if _, err := unmarshalSigned(key, tampered); err == nil {
+			t.Fatal("期望篡改后的快照校验失败，实际没有返回错误")
+		}
+	})
+
+	t.Run("用错误的密钥解析会校验失败", func(t *testing.T) {
+		data, err := marshalSigned(key, rows)
+		if err != nil {
+			t.Fatalf("marshalSigned: %v", err)
+		}
+		if _, err := unmarshalSigned([]byte("wrong-key"), data); err == nil {
+			t.Fatal("期望用错误密钥解析会校验失败，实际没有返回错误")
+		}
+	})
+}
+
+// replaceRowsKeepHMAC 只替换快照 JSON 里的行数据片段，模拟攻击者直接改写存储里的
+// 快照内容（而不经过 marshalSigned 重新计算 HMAC）的场景。
+func replaceRowsKeepHMAC(data, old, new string) string {
+	out := ""
+	replaced := false
+	for i := 0; i < len(data); i++ {
+		if !replaced && i+len(old) <= len(data) && data[i:i+len(old)] == old {
+			out += new
+			i += len(old) - 1
+			replaced = true
+			continue
+		}
+		out += string(data[i])
+	}
+	return out
+}
@@ -0,0 +1,65 @@
+// Package store 提供可插拔的基线快照存储后端，取代仅保存在内存中的 initialState。
+package store
+
+import "fmt"
+
+// Row 是一行表数据，键为列名。
+type Row = map[string]interface{}
+
+// BaselineStore 持久化受保护表的可信基线快照，并记录发现的差异。
+//
+// Save 只应在用户显式执行 `acg-safeguard trust-current` 时被调用，
+// 这样攻破数据库的攻击者无法顺带悄悄改写基线。
+type BaselineStore interface {
+	// Load 读取某张表已持久化的基线快照；若尚未保存过，返回 (nil, nil)。
+	Load(table string) ([]Row, error)
+	// Save 把当前状态写为新的可信基线。
+	Save(table string, rows []Row) error
+	// AppendDiff 追加一条发现的差异记录，用于事后审计。
+	AppendDiff(table string, diff string) error
+}
+
+// Config 描述基线存储后端的选择与参数。
+type Config struct {
+	// Type 是后端类型：file（默认）、bolt、redis。
+	Type string `yaml:"type"`
+
+	// FileDir 是 file 后端下基线快照 JSON 文件所在目录。
+	FileDir string `yaml:"file_dir"`
+
+	// BoltPath 是 bolt 后端下的 BoltDB 文件路径。
+	BoltPath string `yaml:"bolt_path"`
+
+	// RedisAddr/RedisPassword/RedisDB 是 redis 后端的连接参数。
+	RedisAddr     string `yaml:"redis_addr"`
+	RedisPassword string `yaml:"redis_password"`
+	RedisDB       int    `yaml:"redis_db"`
+
+	// Key 是基线快照的 HMAC 签名密钥，只存在于本配置文件中，不会写入存储本身。
+	// 为空时等价于用空密钥签名——仍能检测到普通的误写/损坏，但防不住知道
+	// 存储格式的攻击者伪造签名，应在生产环境中配置为非空值。
+	Key string `yaml:"key"`
+}
+
+// New 根据配置构造对应的 BaselineStore 实现。
+func New(cfg Config) (BaselineStore, error) {
+	key := []byte(cfg.Key)
+	switch cfg.Type {
+	case "", "file":
+		dir := cfg.FileDir
+		if dir == "" {
+			dir = "acg_safeguard_baseline"
+		}
+		return NewFileStore(dir, key)
+	case "bolt":
+		path := cfg.BoltPath
+		if path == "" {
+			path = "acg_safeguard_baseline.db"
+		}
+		return NewBoltStore(path, key)
+	case "redis":
+		return NewRedisStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, key)
+	default:
+		return nil, fmt.Errorf("未知的基线存储类型: %s", cfg.Type)
+	}
+}
@@ -0,0 +1,125 @@
+// Package watcher 基于 fsnotify 监听一个目录树的变更，只在受影响的路径上
+// 触发回调，避免像轮询那样每隔几秒就对整个目录重新计算哈希。
+package watcher
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Options 配置一个 Watcher。
+type Options struct {
+	// SourceDir 是需要递归监听的源目录。
+	SourceDir string
+	// FullScanInterval 是全量扫描兜底的周期；fsnotify 事件丢失
+	// （例如 inotify 队列溢出）期间发生的变更，会在下一次全量扫描时被补上。
+	FullScanInterval time.Duration
+	// OnChange 在源目录下某个文件被创建或修改时调用，参数是该文件以 SourceDir 为前缀的路径
+	// （SourceDir 为相对路径时它也是相对路径）。
+	OnChange func(path string)
+	// OnRemove 在源目录下某个文件被删除或重命名时调用，参数的形式与 OnChange 相同。
+	OnRemove func(path string)
+	// OnFullScan 在每次全量扫描兜底时调用。
+	OnFullScan func()
+	// Logger 用于记录监听过程中的错误；为 nil 时不记录。
+	Logger *log.Logger
+}
+
+// Watcher 监听 Options.SourceDir 下的文件变更。
+type Watcher struct {
+	opts Options
+	fsw  *fsnotify.Watcher
+}
+
+// New 创建一个 Watcher，并为 SourceDir 下现有的每一级子目录注册监听。
+func New(opts Options) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("创建 fsnotify 监听器失败: %w", err)
+	}
+
+	w := &Watcher{opts: opts, fsw: fsw}
+	if err := w.addRecursive(opts.SourceDir); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// addRecursive 为 dir 及其所有子目录注册 fsnotify 监听。fsnotify 不支持
+// 递归监听，所以需要显式遍历目录树逐一 Add。
+func (w *Watcher) addRecursive(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("无法访问路径 %s: %w", path, err)
+		}
+		if info.IsDir() {
+			if err := w.fsw.Add(path); err != nil {
+				return fmt.Errorf("监听目录 %s 失败: %w", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Run 阻塞式地消费 fsnotify 事件并按周期触发全量扫描兜底，直到监听器被关闭。
+func (w *Watcher) Run() error {
+	ticker := time.NewTicker(w.opts.FullScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			if w.opts.Logger != nil {
+				w.opts.Logger.Printf("fsnotify 错误: %v", err)
+			}
+		case <-ticker.C:
+			if w.opts.OnFullScan != nil {
+				w.opts.OnFullScan()
+			}
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	switch {
+	case event.Op&fsnotify.Create != 0:
+		info, err := os.Stat(event.Name)
+		if err == nil && info.IsDir() {
+			// 新建的子目录需要补上监听，否则其内部变更不会被发现。
+			if err := w.addRecursive(event.Name); err != nil && w.opts.Logger != nil {
+				w.opts.Logger.Printf("监听新目录失败: %v", err)
+			}
+			return
+		}
+		if w.opts.OnChange != nil {
+			w.opts.OnChange(event.Name)
+		}
+	case event.Op&fsnotify.Write != 0:
+		if w.opts.OnChange != nil {
+			w.opts.OnChange(event.Name)
+		}
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		if w.opts.OnRemove != nil {
+			w.opts.OnRemove(event.Name)
+		}
+	}
+}
+
+// Close 关闭底层的 fsnotify 监听器。
+func (w *Watcher) Close() error {
+	return w.fsw.Close()
+}